@@ -0,0 +1,68 @@
+//go:build cgo
+
+package jq
+
+// #include "jq.h"
+import "C"
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"unsafe"
+)
+
+// CompileWithArgs compiles proc into a new Vm with named variables bound, visible
+// inside the filter as $name, mirroring the jq CLI's --arg and --argjson flags:
+// values in args are bound as their string form (jq's --arg always binds a JSON
+// string, regardless of the Go value's type), while values in jsonArgs are
+// marshalled with encoding/json and bound as the resulting jq value (jq's
+// --argjson). Use WithEnv to also bind $ENV.
+func CompileWithArgs(proc string, args map[string]interface{}, jsonArgs map[string]interface{}, opts ...Option) (*Vm, error) {
+	var cfg compileOptions
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	bindings := C.jv_object()
+	for name, val := range args {
+		bindings = C.jv_object_set(bindings, jvString(name), jvString(fmt.Sprint(val)))
+	}
+	for name, val := range jsonArgs {
+		jv, err := jvFromJSON(val)
+		if err != nil {
+			C.jv_free(bindings)
+			return nil, err
+		}
+		bindings = C.jv_object_set(bindings, jvString(name), jv)
+	}
+
+	return newVm(proc, &cfg, bindings, nil)
+}
+
+// jvFromJSON marshals val and parses the result into a jv.
+func jvFromJSON(val interface{}) (C.jv, error) {
+	js, err := json.Marshal(val)
+	if err != nil {
+		return C.jv_invalid(), err
+	}
+	jv := C.jv_parse_sized((*C.char)(unsafe.Pointer(&js[0])), C.int(len(js)))
+	if !isValid(jv) {
+		return jv, jvError(jv)
+	}
+	return jv, nil
+}
+
+// environObject builds a jv object of the process's environment variables, for
+// binding as $ENV.
+func environObject() C.jv {
+	obj := C.jv_object()
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		obj = C.jv_object_set(obj, jvString(parts[0]), jvString(parts[1]))
+	}
+	return obj
+}
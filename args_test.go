@@ -0,0 +1,32 @@
+//go:build cgo
+
+package jq_test
+
+import (
+	"github.com/threatgrid/jq-go"
+	"testing"
+)
+
+func TestCompileWithArgs(t *testing.T) {
+	vm, err := jq.CompileWithArgs(`[$name, $count, .]`,
+		map[string]interface{}{"name": "ann"},
+		map[string]interface{}{"count": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vm.Close()
+
+	seq, err := vm.Apply("hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectReturn(t, toStrings(seq), []interface{}{"ann", 3, "hi"})
+}
+
+func toStrings(seq [][]byte) []string {
+	ret := make([]string, len(seq))
+	for i, s := range seq {
+		ret[i] = string(s)
+	}
+	return ret
+}
@@ -0,0 +1,229 @@
+//go:build cgo
+
+package jq
+
+// #include "jq.h"
+import "C"
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// RunOptions is Run with control over the rendering of each result; see
+// DumpOptions.
+func (s *Vm) RunOptions(input []byte, opts DumpOptions, e *error) <-chan []byte {
+	out := make(chan []byte)
+	if len(input) < 1 {
+		close(out)
+		return out
+	}
+
+	jv := jvParse(input)
+	if !isValid(jv) {
+		provideError(e, jvError(jv))
+		close(out)
+		return out
+	}
+
+	go func() {
+		defer close(out)
+		err := processJq(s.jq, jv, func(val C.jv) error {
+			result, err := s.resolveFunctions(val)
+			if err != nil {
+				return err
+			}
+			defer freeJv(result)
+			next := dumpJvOptions(result, opts)
+			if len(next) > 0 {
+				out <- next
+			}
+			return nil
+		})
+		provideError(e, err)
+	}()
+	return out
+}
+
+// DumpOptions applies a filter to zero or more inputs, and writes the rendered
+// results to w, following each with a newline. See DumpOptions (the type) for the
+// available rendering controls.
+func (s *Vm) DumpOptions(w io.Writer, opts DumpOptions, input ...interface{}) error {
+	for _, inp := range input {
+		js, err := json.Marshal(inp)
+		if err != nil {
+			return err
+		}
+		var rerr error
+		for ret := range s.RunOptions(js, opts, &rerr) {
+			if _, err := w.Write(ret); err != nil {
+				return err
+			}
+			if _, err := w.Write([]byte{'\n'}); err != nil {
+				return err
+			}
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+	return nil
+}
+
+// dumpJvOptions renders a JQ value as JSON text per opts. The builtin jv_dump_term
+// (and jv_dump) is extremely inefficient, repeatedly using strlen/strcat to build
+// a string, hence this hand-rolled encoder.
+func dumpJvOptions(jv C.jv, opts DumpOptions) []byte {
+	scheme := opts.ColorScheme
+	if opts.Color && scheme == (ColorScheme{}) {
+		scheme = DefaultColorScheme
+	}
+	d := &dumper{opts: opts, scheme: scheme}
+	d.value(jv, 0)
+	return d.buf.Bytes()
+}
+
+type dumper struct {
+	buf    bytes.Buffer
+	opts   DumpOptions
+	scheme ColorScheme
+}
+
+func (d *dumper) color(code, text string) {
+	if !d.opts.Color || code == "" {
+		d.buf.WriteString(text)
+		return
+	}
+	d.buf.WriteString("\x1b[")
+	d.buf.WriteString(code)
+	d.buf.WriteRune('m')
+	d.buf.WriteString(text)
+	d.buf.WriteString("\x1b[0m")
+}
+
+func (d *dumper) punct(r rune) {
+	d.color(d.scheme.Punct, string(r))
+}
+
+func (d *dumper) newline(depth int) {
+	if d.opts.Indent <= 0 {
+		return
+	}
+	d.buf.WriteRune('\n')
+	d.buf.WriteString(strings.Repeat(" ", depth*d.opts.Indent))
+}
+
+func (d *dumper) value(jv C.jv, depth int) {
+	switch C.jv_get_kind(jv) {
+	case C.JV_KIND_NULL:
+		d.color(d.scheme.Null, "null")
+	case C.JV_KIND_TRUE:
+		d.color(d.scheme.Bool, "true")
+	case C.JV_KIND_FALSE:
+		d.color(d.scheme.Bool, "false")
+	case C.JV_KIND_NUMBER:
+		d.number(jv)
+	case C.JV_KIND_ARRAY:
+		d.array(jv, depth)
+	case C.JV_KIND_OBJECT:
+		d.object(jv, depth)
+	case C.JV_KIND_STRING:
+		d.string(jv)
+	default:
+		panic(int(C.jv_get_kind(jv)))
+	}
+}
+
+func (d *dumper) number(jv C.jv) {
+	// jv_number_value has already truncated jv to a float64 by this point, so any
+	// integer beyond 2^53 has already lost precision; jv_is_integer only lets us
+	// pick a rendering that doesn't lose more of it on top of that.
+	n := float64(C.jv_number_value(jv))
+	var text string
+	if C.jv_is_integer(jv) != 0 {
+		// Format with 'f' and no exponent, so an integral value that does fit
+		// exactly in a float64 isn't rendered in scientific notation the way
+		// json.Marshal's default float formatting can.
+		text = strconv.FormatFloat(n, 'f', -1, 64)
+	} else {
+		p, err := json.Marshal(n)
+		if err != nil {
+			panic(err)
+		}
+		text = string(p)
+	}
+	d.color(d.scheme.Number, text)
+}
+
+func (d *dumper) string(jv C.jv) {
+	ptr := C.jv_string_value(jv)
+	ct := C.jv_string_length_bytes(C.jv_copy(jv))
+	p, err := json.Marshal(C.GoStringN(ptr, ct))
+	if err != nil {
+		panic(err)
+	}
+	d.color(d.scheme.String, string(p))
+}
+
+func (d *dumper) key(jv C.jv) {
+	ptr := C.jv_string_value(jv)
+	ct := C.jv_string_length_bytes(C.jv_copy(jv))
+	p, err := json.Marshal(C.GoStringN(ptr, ct))
+	if err != nil {
+		panic(err)
+	}
+	d.color(d.scheme.Key, string(p))
+}
+
+func (d *dumper) object(x C.jv, depth int) {
+	var keys C.jv
+	if d.opts.SortKeys {
+		keys = C.jv_keys(C.jv_copy(x))
+	} else {
+		keys = C.jv_keys_unsorted(C.jv_copy(x))
+	}
+	defer C.jv_free(keys)
+	ct := C.jv_array_length(C.jv_copy(keys))
+
+	d.punct('{')
+	for i := C.int(0); i < ct; i++ {
+		key := C.jv_array_get(C.jv_copy(keys), i)
+		if i > 0 {
+			d.punct(',')
+		}
+		d.newline(depth + 1)
+		d.key(key)
+		d.punct(':')
+		if d.opts.Indent > 0 {
+			d.buf.WriteRune(' ')
+		}
+		val := C.jv_object_get(C.jv_copy(x), key)
+		d.value(val, depth+1)
+		C.jv_free(val)
+	}
+	if ct > 0 {
+		d.newline(depth)
+	}
+	d.punct('}')
+}
+
+func (d *dumper) array(x C.jv, depth int) {
+	ct := C.jv_array_length(C.jv_copy(x))
+
+	d.punct('[')
+	for i := C.int(0); i < ct; i++ {
+		val := C.jv_array_get(C.jv_copy(x), i)
+		if i > 0 {
+			d.punct(',')
+		}
+		d.newline(depth + 1)
+		d.value(val, depth+1)
+		C.jv_free(val)
+	}
+	if ct > 0 {
+		d.newline(depth)
+	}
+	d.punct(']')
+}
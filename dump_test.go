@@ -0,0 +1,62 @@
+//go:build cgo
+
+package jq_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/threatgrid/jq-go"
+)
+
+func TestDumpOptionsIndentAndSort(t *testing.T) {
+	vm, err := jq.Compile(`.`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vm.Close()
+
+	var buf bytes.Buffer
+	opts := jq.DumpOptions{Indent: 2, SortKeys: true}
+	if err := vm.DumpOptions(&buf, opts, map[string]interface{}{"b": 1, "a": false}); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := "{\n  \"a\": false,\n  \"b\": 1\n}\n"
+	if buf.String() != expect {
+		t.Errorf("expected: %q, got: %q", expect, buf.String())
+	}
+}
+
+func TestDumpOptionsFalseAndIntegers(t *testing.T) {
+	vm, err := jq.Compile(`.`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vm.Close()
+
+	var buf bytes.Buffer
+	if err := vm.DumpOptions(&buf, jq.DumpOptions{}, false, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	// the original dumper wrote JV_KIND_FALSE as "true"; it must now round-trip.
+	expect := "false\n100\n"
+	if buf.String() != expect {
+		t.Errorf("expected: %q, got: %q", expect, buf.String())
+	}
+}
+
+func TestColorSchemeFromSpec(t *testing.T) {
+	scheme, err := jq.ColorSchemeFromSpec("1;30:0;37:0;37:0;37:0;32:1;37")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scheme.Null != "1;30" || scheme.Punct != "1;37" {
+		t.Errorf("unexpected scheme: %+v", scheme)
+	}
+
+	if _, err := jq.ColorSchemeFromSpec("too:few"); err == nil {
+		t.Error("expected an error for a malformed spec")
+	}
+}
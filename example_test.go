@@ -1,11 +1,11 @@
 package jq_test
 
 import (
-	"."
+	"github.com/threatgrid/jq-go"
 	"os"
 )
 
-func ExampleDump_Inverse() {
+func ExampleDump_inverse() {
 	jq.Dump(os.Stdout, "select(. != 0) | 1 / .", 1, 0, 2, 4)
 	// Output:
 	// 1
@@ -0,0 +1,175 @@
+//go:build cgo
+
+package jq
+
+// #include "jq.h"
+// #include <stdlib.h>
+import "C"
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// the object key used to tag a jv produced by a WithFunction-generated def as a
+// pending call into Go, and the keys carrying its input and arguments.
+const (
+	gofnTagKey  = "$gofn"
+	gofnInKey   = "in"
+	gofnArgsKey = "args"
+)
+
+// buildGoFuncPreamble generates one jq def per registered function. Each def
+// ignores its arguments' actual jq semantics and instead packages the call site's
+// input and argument values into a tagged object; resolveFunctions recognises and
+// resolves that object, wherever it ends up nested, after the value leaves
+// jq_next.
+func buildGoFuncPreamble(functions map[string]goFuncDef) string {
+	var b strings.Builder
+	for name, def := range functions {
+		params := make([]string, def.arity)
+		for i := range params {
+			params[i] = fmt.Sprintf("a%d", i)
+		}
+		paramList := ""
+		if len(params) > 0 {
+			paramList = "(" + strings.Join(params, "; ") + ")"
+		}
+		fmt.Fprintf(&b, "def %s%s: {%q: %q, %q: ., %q: [%s]};\n",
+			name, paramList, gofnTagKey, name, gofnInKey, gofnArgsKey, strings.Join(params, ", "))
+	}
+	return b.String()
+}
+
+// resolveFunctions walks val looking for tagged call requests produced by a
+// WithFunction-generated def, at any depth (not just when val itself is one),
+// invokes the matching Go function for each, and returns val with every call
+// resolved to its result in place. val is always consumed.
+func (s *Vm) resolveFunctions(val C.jv) (C.jv, error) {
+	if len(s.functions) == 0 {
+		return val, nil
+	}
+	return s.resolve(val)
+}
+
+// resolve recursively resolves pending calls within val and returns the result;
+// val is always consumed.
+func (s *Vm) resolve(val C.jv) (C.jv, error) {
+	switch C.jv_get_kind(val) {
+	case C.JV_KIND_OBJECT:
+		if name, ok := s.gofnTag(val); ok {
+			return s.dispatch(val, name)
+		}
+		return s.resolveObject(val)
+	case C.JV_KIND_ARRAY:
+		return s.resolveArray(val)
+	default:
+		return val, nil
+	}
+}
+
+// gofnTag reports whether val is a tagged call request for one of s's registered
+// functions, without consuming val.
+func (s *Vm) gofnTag(val C.jv) (string, bool) {
+	tag := C.jv_object_get(C.jv_copy(val), jvString(gofnTagKey))
+	if !isValid(tag) || C.jv_get_kind(tag) != C.JV_KIND_STRING {
+		freeJv(tag)
+		return "", false
+	}
+	name := C.GoString(C.jv_string_value(tag))
+	freeJv(tag)
+	if _, ok := s.functions[name]; !ok {
+		return "", false
+	}
+	return name, true
+}
+
+// dispatch invokes the Go function name with the input and arguments packaged
+// into the tagged call request val, after first resolving any calls nested
+// within them (e.g. from composing two calls with `|`, or passing one as an
+// argument to another). val is always consumed.
+func (s *Vm) dispatch(val C.jv, name string) (C.jv, error) {
+	def := s.functions[name]
+
+	in, err := s.resolve(C.jv_object_get(C.jv_copy(val), jvString(gofnInKey)))
+	if err != nil {
+		freeJv(val)
+		return C.jv_invalid(), err
+	}
+	input := dumpJv(in)
+	freeJv(in)
+
+	argsJv := C.jv_object_get(C.jv_copy(val), jvString(gofnArgsKey))
+	ct := C.jv_array_length(C.jv_copy(argsJv))
+	args := make([]json.RawMessage, 0, ct)
+	for i := C.int(0); i < ct; i++ {
+		resolved, err := s.resolve(C.jv_array_get(C.jv_copy(argsJv), i))
+		if err != nil {
+			freeJv(argsJv)
+			freeJv(val)
+			return C.jv_invalid(), err
+		}
+		args = append(args, dumpJv(resolved))
+		freeJv(resolved)
+	}
+	freeJv(argsJv)
+	freeJv(val)
+
+	out, err := def.fn(input, args)
+	if err != nil {
+		return C.jv_invalid(), fmt.Errorf("jq: calling %q: %w", name, err)
+	}
+	if len(out) == 0 {
+		return C.jv_null(), nil
+	}
+	return C.jv_parse_sized((*C.char)(unsafe.Pointer(&out[0])), C.int(len(out))), nil
+}
+
+// resolveObject rebuilds val with every value resolved recursively; val is
+// always consumed.
+func (s *Vm) resolveObject(val C.jv) (C.jv, error) {
+	keys := C.jv_keys_unsorted(C.jv_copy(val))
+	ct := C.jv_array_length(C.jv_copy(keys))
+	out := C.jv_object()
+	for i := C.int(0); i < ct; i++ {
+		key := C.jv_array_get(C.jv_copy(keys), i)
+		resolved, err := s.resolve(C.jv_object_get(C.jv_copy(val), C.jv_copy(key)))
+		if err != nil {
+			freeJv(key)
+			freeJv(keys)
+			freeJv(val)
+			freeJv(out)
+			return C.jv_invalid(), err
+		}
+		out = C.jv_object_set(out, key, resolved)
+	}
+	freeJv(keys)
+	freeJv(val)
+	return out, nil
+}
+
+// resolveArray rebuilds val with every element resolved recursively; val is
+// always consumed.
+func (s *Vm) resolveArray(val C.jv) (C.jv, error) {
+	ct := C.jv_array_length(C.jv_copy(val))
+	out := C.jv_array()
+	for i := C.int(0); i < ct; i++ {
+		resolved, err := s.resolve(C.jv_array_get(C.jv_copy(val), i))
+		if err != nil {
+			freeJv(val)
+			freeJv(out)
+			return C.jv_invalid(), err
+		}
+		out = C.jv_array_append(out, resolved)
+	}
+	freeJv(val)
+	return out, nil
+}
+
+// jvString builds a new jv string from a Go string.
+func jvString(str string) C.jv {
+	cstr := C.CString(str)
+	defer C.free(unsafe.Pointer(cstr))
+	return C.jv_string(cstr)
+}
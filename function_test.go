@@ -0,0 +1,80 @@
+//go:build cgo
+
+package jq_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/threatgrid/jq-go"
+)
+
+func TestWithFunction(t *testing.T) {
+	double := jq.WithFunction("double", 1, func(input json.RawMessage, args []json.RawMessage) (json.RawMessage, error) {
+		var n float64
+		if err := json.Unmarshal(args[0], &n); err != nil {
+			return nil, err
+		}
+		return json.Marshal(n * 2)
+	})
+
+	vm, err := jq.Compile(`double(.)`, double)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vm.Close()
+
+	var rerr error
+	var got []string
+	for ret := range vm.Run([]byte("21"), &rerr) {
+		got = append(got, string(ret))
+	}
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	expectReturn(t, got, 42.0)
+}
+
+func TestWithFunctionNested(t *testing.T) {
+	double := jq.WithFunction("double", 1, func(input json.RawMessage, args []json.RawMessage) (json.RawMessage, error) {
+		var n float64
+		if err := json.Unmarshal(args[0], &n); err != nil {
+			return nil, err
+		}
+		return json.Marshal(n * 2)
+	})
+
+	vm, err := jq.Compile(`{y: double(.)}`, double)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vm.Close()
+
+	seq, err := vm.Apply(21)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectReturn(t, toStrings(seq), map[string]interface{}{"y": 42.0})
+}
+
+func TestWithFunctionBoundAndComposed(t *testing.T) {
+	double := jq.WithFunction("double", 1, func(input json.RawMessage, args []json.RawMessage) (json.RawMessage, error) {
+		var n float64
+		if err := json.Unmarshal(args[0], &n); err != nil {
+			return nil, err
+		}
+		return json.Marshal(n * 2)
+	})
+
+	vm, err := jq.Compile(`double(.) as $x | [$x, double($x)]`, double)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vm.Close()
+
+	seq, err := vm.Apply(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectReturn(t, toStrings(seq), []interface{}{10.0, 20.0})
+}
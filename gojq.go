@@ -0,0 +1,635 @@
+//go:build !cgo
+
+/*
+	This file provides a pure-Go fallback implementation of the package, backed by
+	github.com/itchyny/gojq, used whenever cgo (and therefore libjq) isn't
+	available: cross-compiling, static musl binaries, or any environment without
+	libjq-dev installed. The CGO-backed implementation in jq.go remains the
+	default when cgo is available, and is generally faster; this backend trades
+	that for portability.
+
+	The public API is identical between the two backends (TestApply in jq_test.go
+	runs unmodified against whichever one a given build selects), but there are a
+	few small semantic gaps to be aware of:
+
+	  - Numbers round-trip through float64 here, same as the CGO backend's own
+	    dumpJv, so very large integers can lose precision in both; gojq's own
+	    arithmetic, however, uses exact big.Int/big.Rat where libjq uses doubles
+	    throughout, so intermediate computations (e.g. bitwise ops on large
+	    numbers) can differ between backends even though final JSON encoding does
+	    not.
+	  - Error message text is not guaranteed to match libjq's wording, only that
+	    an error occurs; callers that match on error strings rather than simply
+	    checking for a non-nil error will see differences.
+	  - gojq represents JSON objects as an ordinary Go map, which has no concept of
+	    insertion order, so RunOptions/DumpOptions always emit object keys sorted
+	    here, regardless of DumpOptions.SortKeys (the cgo backend preserves
+	    insertion order unless SortKeys is set).
+	  - WithFunction calls are dispatched through gojq's own custom-function
+	    support rather than the tagging trick the cgo backend needs (see
+	    function.go), so they have none of that backend's restrictions on where a
+	    call may appear in the filter.
+*/
+package jq
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/itchyny/gojq"
+)
+
+// Dump dumps the results of Apply to a writer, following each result with a newline.
+func Dump(w io.Writer, proc string, input ...interface{}) error {
+	vm, err := Compile(proc)
+	if err != nil {
+		return err
+	}
+	return vm.Dump(w, input...)
+}
+
+// Apply compiles a JQ filter, and applies it to one or more inputs.
+func Apply(proc string, input ...interface{}) ([][]byte, error) {
+	vm, err := Compile(proc)
+	if err != nil {
+		return nil, err
+	}
+	return vm.Apply(input...)
+}
+
+// MustCompile uses Compile to compile a JQ filter, and panics if it fails.
+// This simplifies wrapping a known good filter into global variable.
+func MustCompile(proc string) *Vm {
+	s, err := Compile(proc)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Compile compiles a JQ filter into a new JQ virtual machine. Opts can be used to
+// extend the filter, e.g. with WithFunction to expose Go callbacks as jq builtins.
+func Compile(proc string, opts ...Option) (*Vm, error) {
+	var cfg compileOptions
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	q, err := gojq.Parse(proc)
+	if err != nil {
+		return nil, err
+	}
+	return newVm(q, &cfg, nil, nil, nil)
+}
+
+// A Vm encloses the internal state of a compiled JQ filter machine.  Vm's
+// can be reused, but cannot be used concurrently.
+type Vm struct {
+	code *gojq.Code
+
+	// vars holds the values bound to the variables CompileWithArgs declared with
+	// gojq.WithVariables, in the matching order, passed to code.Run on every call.
+	vars []interface{}
+
+	// moduleDir, if non-empty, is a temporary directory created at compile time
+	// to serve a WithModuleFS filesystem and removed on Close.
+	moduleDir string
+}
+
+// Dump applies a filter to zero or more inputs, and writes the JSON results to an io.Writer.
+func (s *Vm) Dump(w io.Writer, input ...interface{}) error {
+	seq, err := s.Apply(input...)
+	if err != nil {
+		return err
+	}
+	for _, item := range seq {
+		if _, err := w.Write(item); err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte{'\n'}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply runs the JQ filter on each input after using encoding/json to convert to JSON.
+// The results of each run are combined into an array of JSON raw messages.
+// Apply stops on the first error, which could be during Compile or a Run.
+func (s *Vm) Apply(input ...interface{}) ([][]byte, error) {
+	seq := make([][]byte, 0, len(input)*4)
+	for _, inp := range input {
+		js, err := json.Marshal(inp)
+		if err != nil {
+			return seq, err
+		}
+		var rerr error
+		for ret := range s.Run(js, &rerr) {
+			seq = append(seq, ret)
+		}
+		if rerr != nil {
+			return seq, rerr
+		}
+	}
+	return seq, nil
+}
+
+// Run starts the filter with the supplied input, and uses a channel to gather results.
+// When the channel is closed, e will contain the final error, if any.
+// Run may be used consecutively for additional inputs, but not in parallel.
+func (s *Vm) Run(input []byte, e *error) chan []byte {
+	out := make(chan []byte)
+	if len(input) < 1 {
+		close(out)
+		return out
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(input, &v); err != nil {
+		provideError(e, err)
+		close(out)
+		return out
+	}
+
+	go func() {
+		defer close(out)
+		iter := s.code.Run(v, s.vars...)
+		for {
+			res, ok := iter.Next()
+			if !ok {
+				return
+			}
+			if err, ok := res.(error); ok {
+				provideError(e, err)
+				return
+			}
+			js, err := json.Marshal(res)
+			if err != nil {
+				provideError(e, err)
+				return
+			}
+			out <- js
+		}
+	}()
+	return out
+}
+
+// RunReader streams values decoded incrementally from r through the compiled
+// filter, emitting each result on the returned channel. Unlike Run, the whole
+// input does not need to be materialised in memory first, so unbounded NDJSON or
+// concatenated JSON (`{...}{...}`, `1 2 3`) can be processed as it arrives. When
+// the channel is closed, e will contain the first decode or filter error
+// encountered, if any. RunReader may be used consecutively for additional
+// readers, but not in parallel.
+func (s *Vm) RunReader(r io.Reader, e *error) <-chan []byte {
+	out := make(chan []byte)
+
+	go func() {
+		defer close(out)
+		dec := json.NewDecoder(r)
+		for {
+			var v interface{}
+			if err := dec.Decode(&v); err != nil {
+				if err != io.EOF {
+					provideError(e, err)
+				}
+				return
+			}
+			iter := s.code.Run(v, s.vars...)
+			for {
+				res, ok := iter.Next()
+				if !ok {
+					break
+				}
+				if err, ok := res.(error); ok {
+					provideError(e, err)
+					return
+				}
+				js, err := json.Marshal(res)
+				if err != nil {
+					provideError(e, err)
+					return
+				}
+				out <- js
+			}
+		}
+	}()
+	return out
+}
+
+// DumpReader compiles a JQ filter and streams it over values decoded
+// incrementally from r, writing each result to w followed by a newline. See
+// Vm.RunReader for details on streaming semantics.
+func DumpReader(w io.Writer, proc string, r io.Reader) error {
+	vm, err := Compile(proc)
+	if err != nil {
+		return err
+	}
+	var rerr error
+	for item := range vm.RunReader(r, &rerr) {
+		if _, err := w.Write(item); err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte{'\n'}); err != nil {
+			return err
+		}
+	}
+	return rerr
+}
+
+// CompileWithArgs compiles proc into a new Vm with named variables bound, visible
+// inside the filter as $name, mirroring the jq CLI's --arg and --argjson flags:
+// values in args are bound as their string form (jq's --arg always binds a JSON
+// string, regardless of the Go value's type), while values in jsonArgs are
+// marshalled with encoding/json and bound as the resulting jq value (jq's
+// --argjson). Use WithEnv to also bind $ENV.
+func CompileWithArgs(proc string, args map[string]interface{}, jsonArgs map[string]interface{}, opts ...Option) (*Vm, error) {
+	var cfg compileOptions
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	q, err := gojq.Parse(proc)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(args)+len(jsonArgs))
+	values := make([]interface{}, 0, len(args)+len(jsonArgs))
+	for name, val := range args {
+		names = append(names, "$"+name)
+		values = append(values, fmt.Sprint(val))
+	}
+	for name, val := range jsonArgs {
+		v, err := jsonRoundTrip(val)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, "$"+name)
+		values = append(values, v)
+	}
+
+	return newVm(q, &cfg, names, values, nil)
+}
+
+// jsonRoundTrip marshals val and unmarshals the result back into a plain Go
+// value, normalising it into the handful of types gojq itself produces and
+// consumes (map[string]interface{}, []interface{}, string, float64, bool, nil).
+func jsonRoundTrip(val interface{}) (interface{}, error) {
+	js, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(js, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// environMap builds a map of the process's environment variables, for binding
+// as $ENV.
+func environMap() map[string]interface{} {
+	m := make(map[string]interface{})
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		m[parts[0]] = parts[1]
+	}
+	return m
+}
+
+// CompileWithModules compiles proc into a new Vm with its module search path set
+// to searchPaths, so the filter can use `import "mymod" as $m;` / `include
+// "mymod";` to pull in reusable jq library code, mirroring the jq CLI's -L flag.
+func CompileWithModules(proc string, searchPaths []string, opts ...Option) (*Vm, error) {
+	var cfg compileOptions
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	q, err := gojq.Parse(proc)
+	if err != nil {
+		return nil, err
+	}
+	return newVm(q, &cfg, nil, nil, searchPaths)
+}
+
+// newVm compiles q into a Vm, applying every Option the same way regardless of
+// which public Compile* entry point is used: registered functions, $ENV (if
+// WithEnv was given), and the module search path (searchPaths plus any
+// WithModuleFS) are all applied on top of vars/values, which already carry any
+// --arg/--argjson bindings CompileWithArgs built.
+func newVm(q *gojq.Query, cfg *compileOptions, vars []string, values []interface{}, searchPaths []string) (*Vm, error) {
+	allPaths := searchPaths
+	var moduleDir string
+	if cfg.moduleFS != nil {
+		dir, err := materializeModuleFS(cfg.moduleFS)
+		if err != nil {
+			return nil, err
+		}
+		moduleDir = dir
+		allPaths = append([]string{dir}, searchPaths...)
+	}
+
+	if cfg.includeEnv {
+		vars = append(vars, "$ENV")
+		values = append(values, environMap())
+	}
+
+	gopts := goFunctionOpts(cfg)
+	if len(vars) > 0 {
+		gopts = append(gopts, gojq.WithVariables(vars))
+	}
+	if len(allPaths) > 0 {
+		gopts = append(gopts, gojq.WithModuleLoader(gojq.NewModuleLoader(allPaths)))
+	}
+
+	code, err := gojq.Compile(q, gopts...)
+	if err != nil {
+		os.RemoveAll(moduleDir)
+		return nil, err
+	}
+	return &Vm{code: code, vars: values, moduleDir: moduleDir}, nil
+}
+
+// goFunctionOpts converts cfg's registered WithFunction callbacks into gojq
+// compiler options, using gojq's own custom-function support directly rather
+// than the def-and-tag preamble the cgo backend needs.
+func goFunctionOpts(cfg *compileOptions) []gojq.CompilerOption {
+	gopts := make([]gojq.CompilerOption, 0, len(cfg.functions))
+	for name, def := range cfg.functions {
+		gopts = append(gopts, gojq.WithFunction(name, def.arity, def.arity, goFunctionCall(name, def)))
+	}
+	return gopts
+}
+
+// goFunctionCall adapts a GoFunc to the func(any, []any) any signature gojq's
+// WithFunction expects: the input and each argument are marshalled to JSON,
+// passed to def.fn, and its result is unmarshalled back into a plain Go value.
+// Returning an error value is gojq's convention for a function call failing.
+func goFunctionCall(name string, def goFuncDef) func(interface{}, []interface{}) interface{} {
+	return func(input interface{}, args []interface{}) interface{} {
+		in, err := json.Marshal(input)
+		if err != nil {
+			return err
+		}
+		rawArgs := make([]json.RawMessage, len(args))
+		for i, a := range args {
+			b, err := json.Marshal(a)
+			if err != nil {
+				return err
+			}
+			rawArgs[i] = b
+		}
+
+		out, err := def.fn(in, rawArgs)
+		if err != nil {
+			return fmt.Errorf("jq: calling %q: %w", name, err)
+		}
+		if len(out) == 0 {
+			return nil
+		}
+		var v interface{}
+		if err := json.Unmarshal(out, &v); err != nil {
+			return err
+		}
+		return v
+	}
+}
+
+// RunOptions is Run with control over the rendering of each result; see
+// DumpOptions.
+func (s *Vm) RunOptions(input []byte, opts DumpOptions, e *error) <-chan []byte {
+	out := make(chan []byte)
+	if len(input) < 1 {
+		close(out)
+		return out
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(input, &v); err != nil {
+		provideError(e, err)
+		close(out)
+		return out
+	}
+
+	go func() {
+		defer close(out)
+		iter := s.code.Run(v, s.vars...)
+		for {
+			res, ok := iter.Next()
+			if !ok {
+				return
+			}
+			if err, ok := res.(error); ok {
+				provideError(e, err)
+				return
+			}
+			out <- dumpValueOptions(res, opts)
+		}
+	}()
+	return out
+}
+
+// DumpOptions applies a filter to zero or more inputs, and writes the rendered
+// results to w, following each with a newline. See DumpOptions (the type) for the
+// available rendering controls.
+func (s *Vm) DumpOptions(w io.Writer, opts DumpOptions, input ...interface{}) error {
+	for _, inp := range input {
+		js, err := json.Marshal(inp)
+		if err != nil {
+			return err
+		}
+		var rerr error
+		for ret := range s.RunOptions(js, opts, &rerr) {
+			if _, err := w.Write(ret); err != nil {
+				return err
+			}
+			if _, err := w.Write([]byte{'\n'}); err != nil {
+				return err
+			}
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+	return nil
+}
+
+// dumpValueOptions renders a gojq result value as JSON text per opts.
+func dumpValueOptions(v interface{}, opts DumpOptions) []byte {
+	scheme := opts.ColorScheme
+	if opts.Color && scheme == (ColorScheme{}) {
+		scheme = DefaultColorScheme
+	}
+	d := &goDumper{opts: opts, scheme: scheme}
+	d.value(v, 0)
+	return d.buf.Bytes()
+}
+
+// goDumper is dumper's counterpart for the gojq backend: the same rendering
+// logic, but walking the plain Go values gojq produces (nil, bool, float64,
+// *big.Int, string, []interface{}, map[string]interface{}) instead of a C.jv.
+type goDumper struct {
+	buf    bytes.Buffer
+	opts   DumpOptions
+	scheme ColorScheme
+}
+
+func (d *goDumper) color(code, text string) {
+	if !d.opts.Color || code == "" {
+		d.buf.WriteString(text)
+		return
+	}
+	d.buf.WriteString("\x1b[")
+	d.buf.WriteString(code)
+	d.buf.WriteRune('m')
+	d.buf.WriteString(text)
+	d.buf.WriteString("\x1b[0m")
+}
+
+func (d *goDumper) punct(r rune) {
+	d.color(d.scheme.Punct, string(r))
+}
+
+func (d *goDumper) newline(depth int) {
+	if d.opts.Indent <= 0 {
+		return
+	}
+	d.buf.WriteRune('\n')
+	d.buf.WriteString(strings.Repeat(" ", depth*d.opts.Indent))
+}
+
+func (d *goDumper) value(v interface{}, depth int) {
+	switch x := v.(type) {
+	case nil:
+		d.color(d.scheme.Null, "null")
+	case bool:
+		if x {
+			d.color(d.scheme.Bool, "true")
+		} else {
+			d.color(d.scheme.Bool, "false")
+		}
+	case int:
+		d.color(d.scheme.Number, strconv.Itoa(x))
+	case float64:
+		d.number(x)
+	case *big.Int:
+		d.color(d.scheme.Number, x.String())
+	case string:
+		d.string(x)
+	case []interface{}:
+		d.array(x, depth)
+	case map[string]interface{}:
+		d.object(x, depth)
+	default:
+		panic(fmt.Sprintf("jq: dumping unexpected value type %T", v))
+	}
+}
+
+func (d *goDumper) number(n float64) {
+	var text string
+	if n == math.Trunc(n) && !math.IsInf(n, 0) {
+		// Same rationale as the cgo backend's dumper.number: format integral
+		// values with 'f' and no exponent, rather than risk scientific notation.
+		text = strconv.FormatFloat(n, 'f', -1, 64)
+	} else {
+		p, err := json.Marshal(n)
+		if err != nil {
+			panic(err)
+		}
+		text = string(p)
+	}
+	d.color(d.scheme.Number, text)
+}
+
+func (d *goDumper) string(s string) {
+	p, err := json.Marshal(s)
+	if err != nil {
+		panic(err)
+	}
+	d.color(d.scheme.String, string(p))
+}
+
+func (d *goDumper) object(m map[string]interface{}, depth int) {
+	// map iteration order is unspecified, and gojq's map[string]interface{}
+	// representation has no concept of insertion order to fall back to, so keys
+	// are always sorted here regardless of opts.SortKeys.
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	d.punct('{')
+	for i, k := range keys {
+		if i > 0 {
+			d.punct(',')
+		}
+		d.newline(depth + 1)
+		p, err := json.Marshal(k)
+		if err != nil {
+			panic(err)
+		}
+		d.color(d.scheme.Key, string(p))
+		d.punct(':')
+		if d.opts.Indent > 0 {
+			d.buf.WriteRune(' ')
+		}
+		d.value(m[k], depth+1)
+	}
+	if len(keys) > 0 {
+		d.newline(depth)
+	}
+	d.punct('}')
+}
+
+func (d *goDumper) array(a []interface{}, depth int) {
+	d.punct('[')
+	for i, v := range a {
+		if i > 0 {
+			d.punct(',')
+		}
+		d.newline(depth + 1)
+		d.value(v, depth+1)
+	}
+	if len(a) > 0 {
+		d.newline(depth)
+	}
+	d.punct(']')
+}
+
+func provideError(e *error, err error) {
+	switch {
+	case e == nil:
+		return
+	case err == nil:
+		return
+	}
+	*e = err
+}
+
+// Close closes a JQ state, releasing resources. The pure-Go backend holds
+// nothing that needs releasing beyond a WithModuleFS temporary directory, if
+// any.
+func (s *Vm) Close() error {
+	if s == nil {
+		return nil
+	}
+	if s.moduleDir != "" {
+		os.RemoveAll(s.moduleDir)
+		s.moduleDir = ""
+	}
+	return nil
+}
@@ -1,3 +1,5 @@
+//go:build cgo
+
 /*
 	This package wraps https://github.com/stedolan/jq as a virtual machine. This
 	provides Go programmers with a way to filter JSON data using JQ.
@@ -5,6 +7,9 @@
 	Building this package requires a very current build of JQ; earlier releases
 	do not provide JQ as a separate library component.  For a more stable and
 	portable implementation, see https://github.com/threatgrid/jqpipe-go
+
+	When built without cgo, this package falls back to a pure-Go implementation;
+	see gojq.go.
 */
 package jq
 
@@ -40,10 +45,10 @@ static const char* get_jv_error(jv value) {
 */
 import "C"
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"io"
+	"os"
 	"unsafe"
 )
 
@@ -90,23 +95,28 @@ func MustCompile(proc string) *Vm {
 	return s
 }
 
-// Compile compiles a JQ filter into a new JQ virtual machine.
-func Compile(proc string) (*Vm, error) {
-	s := new(Vm)
-	s.jq = C.jq_init()
-
-	err := compileJq(s.jq, proc)
-	if err != nil {
-		s.Close()
-		return nil, err
+// Compile compiles a JQ filter into a new JQ virtual machine. Opts can be used to
+// extend the filter, e.g. with WithFunction to expose Go callbacks as jq builtins.
+func Compile(proc string, opts ...Option) (*Vm, error) {
+	var cfg compileOptions
+	for _, opt := range opts {
+		opt(&cfg)
 	}
-	return s, nil
+	return newVm(proc, &cfg, C.jv_object(), nil)
 }
 
 // A Vm encloses the internal state of a compiled JQ filter machine.  Vm's
 // can be reused, but cannot be used concurrently.
 type Vm struct {
 	jq *C.jq_state
+
+	// functions holds the Go callbacks registered via WithFunction at Compile time,
+	// keyed by the name they're callable as from the filter.
+	functions map[string]goFuncDef
+
+	// moduleDir, if non-empty, is a temporary directory created at compile time
+	// to serve a WithModuleFS filesystem and removed on Close.
+	moduleDir string
 }
 
 // Dump applies a filter to zero or more inputs, and writes the JSON results to an io.Writer.
@@ -168,12 +178,17 @@ func (s *Vm) Run(input []byte, e *error) chan []byte {
 	go func() {
 		defer close(out)
 		//TODO: if invalid in, report an error
-		err := processJq(s.jq, jv, func(val C.jv) {
-			defer freeJv(val)
-			next := dumpJv(val)
+		err := processJq(s.jq, jv, func(val C.jv) error {
+			result, err := s.resolveFunctions(val)
+			if err != nil {
+				return err
+			}
+			defer freeJv(result)
+			next := dumpJv(result)
 			if len(next) > 0 {
 				out <- next
 			}
+			return nil
 		})
 		provideError(e, err)
 	}()
@@ -197,11 +212,16 @@ func (s *Vm) Close() error {
 	}
 	C.jq_teardown(&s.jq)
 	s.jq = nil
+	if s.moduleDir != "" {
+		os.RemoveAll(s.moduleDir)
+		s.moduleDir = ""
+	}
 	return nil
 }
 
-// starts JQ with a value, and visits each result; the value must be freeJv'd by the visitor.
-func processJq(jq *C.jq_state, input C.jv, visit func(val C.jv)) error {
+// starts JQ with a value, and visits each result; the value must be freeJv'd by the
+// visitor. If visit returns an error, iteration stops and that error is returned.
+func processJq(jq *C.jq_state, input C.jv, visit func(val C.jv) error) error {
 	var jv C.jv
 	C.jq_start(jq, input, 0)
 	for {
@@ -209,7 +229,9 @@ func processJq(jq *C.jq_state, input C.jv, visit func(val C.jv)) error {
 		if !isValid(jv) {
 			break
 		}
-		visit(jv)
+		if err := visit(jv); err != nil {
+			return err
+		}
 	}
 	defer freeJv(jv)
 	return jvError(jv)
@@ -220,15 +242,60 @@ func isValid(jv C.jv) bool {
 	return C.jv_is_valid(jv) != 0
 }
 
-// compileJq compiles a program into the jq interpreter
-func compileJq(jq *C.jq_state, src string) error {
+// newVm builds a Vm for proc, applying every Option the same way regardless of
+// which public Compile* entry point is used: registered functions, $ENV (if
+// WithEnv was given), and the module search path (searchPaths plus any
+// WithModuleFS) are all layered on top of bindings, which already carries any
+// --arg/--argjson values CompileWithArgs bound. bindings is consumed.
+func newVm(proc string, cfg *compileOptions, bindings C.jv, searchPaths []string) (*Vm, error) {
+	s := new(Vm)
+	s.jq = C.jq_init()
+	s.functions = cfg.functions
+
+	allPaths := searchPaths
+	if cfg.moduleFS != nil {
+		dir, err := materializeModuleFS(cfg.moduleFS)
+		if err != nil {
+			C.jv_free(bindings)
+			s.Close()
+			return nil, err
+		}
+		s.moduleDir = dir
+		allPaths = append([]string{dir}, searchPaths...)
+	}
+	if len(allPaths) > 0 {
+		paths := C.jv_array()
+		for _, p := range allPaths {
+			paths = C.jv_array_append(paths, jvString(p))
+		}
+		C.jq_set_attr(s.jq, jvString("JQ_LIBRARY_PATH"), paths)
+	}
+
+	if cfg.includeEnv {
+		bindings = C.jv_object_set(bindings, jvString("ENV"), environObject())
+	}
+
+	src := proc
+	if len(cfg.functions) > 0 {
+		src = buildGoFuncPreamble(cfg.functions) + proc
+	}
+
+	if err := compileJqArgs(s.jq, src, bindings); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// compileJqArgs compiles a program into the jq interpreter with a jv object of
+// named variable bindings, as produced by CompileWithArgs. bindings is consumed.
+func compileJqArgs(jq *C.jq_state, src string, bindings C.jv) error {
 	var msg *C.char
 	C.set_err_cb(jq, &msg)
 	defer C.set_err_cb(jq, nil)
 	csrc := C.CString(src)
 	defer C.free(unsafe.Pointer(csrc))
-	// TODO: use a SyntaxError type to split up Error from Expr
-	if C.jq_compile(jq, csrc) == 0 {
+	if C.jq_compile_args(jq, csrc, bindings) == 0 {
 		return errors.New(C.GoString(msg))
 	}
 	return nil
@@ -249,17 +316,6 @@ func jvParse(p []byte) C.jv {
 		C.int(len(p)))
 }
 
-/*
-// dumpJv copies a JQ value to a byte array
-func dumpJv(jv C.jv) []byte {
-	str := C.jv_dump_string(C.jv_copy(jv), 0)
-	ptr := C.jv_string_value(str)
-	sz := C.jv_string_length_bytes(str)
-	p := C.GoBytes(unsafe.Pointer(ptr), sz)
-	return p
-}
-*/
-
 // if jv is invalid, and references an error message, return a proper Go error
 func jvError(jv C.jv) error {
 	ptr := C.get_jv_error(jv)
@@ -273,81 +329,8 @@ func jvError(jv C.jv) error {
 // - jv_string_value
 // - jv_is_valid
 
-// the builtin jv_dump_term (and jv_dump) is extremely inefficient, repeatedly using strlen / strcat to
-// build a string
+// dumpJv copies a JQ value to a byte array as compact JSON. See dump.go for the
+// configurable encoder this delegates to.
 func dumpJv(jv C.jv) []byte {
-	var buf bytes.Buffer
-	dumpValue(&buf, jv)
-	return buf.Bytes()
-}
-
-func dumpValue(buf *bytes.Buffer, jv C.jv) {
-	switch C.jv_get_kind(jv) {
-	case C.JV_KIND_NULL:
-		buf.WriteString("null")
-	case C.JV_KIND_TRUE:
-		buf.WriteString("true")
-	case C.JV_KIND_FALSE:
-		buf.WriteString("true")
-	case C.JV_KIND_NUMBER:
-		d := float64(C.jv_number_value(jv))
-		p, err := json.Marshal(d)
-		if err != nil {
-			panic(err)
-		}
-		buf.Write(p)
-	case C.JV_KIND_ARRAY:
-		dumpArray(buf, jv)
-	case C.JV_KIND_OBJECT:
-		dumpObject(buf, jv)
-	case C.JV_KIND_STRING:
-		dumpString(buf, jv)
-	default:
-		panic(int(C.jv_get_kind(jv)))
-	}
-}
-
-func dumpObject(buf *bytes.Buffer, x C.jv) {
-	keys := C.jv_keys(C.jv_copy(x))
-	defer C.jv_free(keys)
-	ct := C.jv_array_length(C.jv_copy(keys))
-
-	buf.WriteRune('{')
-	defer buf.WriteRune('}')
-	for i := C.int(0); i < ct; i++ {
-		key := C.jv_array_get(C.jv_copy(keys), i)
-		if i > 0 {
-			buf.WriteRune(',')
-		}
-		dumpString(buf, key)
-		buf.WriteRune(':')
-		val := C.jv_object_get(C.jv_copy(x), key)
-		dumpValue(buf, val)
-		C.jv_free(val)
-	}
-}
-
-func dumpArray(buf *bytes.Buffer, x C.jv) {
-	ct := C.jv_array_length(C.jv_copy(x))
-
-	buf.WriteRune('[')
-	defer buf.WriteRune(']')
-	for i := C.int(0); i < ct; i++ {
-		val := C.jv_array_get(C.jv_copy(x), i)
-		if i > 0 {
-			buf.WriteRune(',')
-		}
-		dumpValue(buf, val)
-		C.jv_free(val)
-	}
-}
-
-func dumpString(buf *bytes.Buffer, x C.jv) {
-	ptr := C.jv_string_value(x)
-	ct := C.jv_string_length_bytes(C.jv_copy(x))
-	p, err := json.Marshal(C.GoStringN(ptr, ct))
-	if err != nil {
-		panic(err)
-	}
-	buf.Write(p)
+	return dumpJvOptions(jv, DumpOptions{})
 }
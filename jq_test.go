@@ -1,9 +1,10 @@
 package jq_test
 
 import (
-	"."
 	"encoding/json"
 	"testing"
+
+	"github.com/threatgrid/jq-go"
 )
 
 func TestApply(t *testing.T) {
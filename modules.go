@@ -0,0 +1,17 @@
+//go:build cgo
+
+package jq
+
+// #include "jq.h"
+import "C"
+
+// CompileWithModules compiles proc into a new Vm with its module search path set
+// to searchPaths, so the filter can use `import "mymod" as $m;` / `include
+// "mymod";` to pull in reusable jq library code, mirroring the jq CLI's -L flag.
+func CompileWithModules(proc string, searchPaths []string, opts ...Option) (*Vm, error) {
+	var cfg compileOptions
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return newVm(proc, &cfg, C.jv_object(), searchPaths)
+}
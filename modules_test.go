@@ -0,0 +1,27 @@
+//go:build cgo
+
+package jq_test
+
+import (
+	"github.com/threatgrid/jq-go"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCompileWithModules(t *testing.T) {
+	fsys := fstest.MapFS{
+		"double.jq": &fstest.MapFile{Data: []byte(`def double: . * 2;`)},
+	}
+
+	vm, err := jq.CompileWithModules(`import "double" as d; d::double`, nil, jq.WithModuleFS(fsys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vm.Close()
+
+	seq, err := vm.Apply(21)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectReturn(t, toStrings(seq), 42.0)
+}
@@ -0,0 +1,187 @@
+package jq
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// GoFunc is a Go callback exposed to a JQ filter as a named builtin, registered
+// with WithFunction. input is the current `.` at the call site and args holds
+// each argument's value, evaluated and marshalled to raw JSON; the returned bytes
+// are parsed back into a jq value and become the result of calling name(args...)
+// from the filter.
+//
+// A call may appear anywhere in the final result's structure — as the whole
+// result, piped onward, bound with `as` (`lookup(.id) as $x | ...`), or nested
+// inside object/array construction (`{y: lookup(.id)}`). The pure-Go backend
+// uses gojq's native custom-function support, so this always just works. The
+// cgo backend has no such hook into libjq itself; it instead tags each call site
+// with a jq def and walks the entire result tree looking for pending tags before
+// handing the value to the caller (see function.go), which means a call may NOT
+// be consumed by one of jq's own operators before that point (arithmetic,
+// comparison, `select`, indexing, and so on): e.g. `1 + lookup(.id)` will fail
+// with a jq type error rather than calling lookup.
+type GoFunc func(input json.RawMessage, args []json.RawMessage) (json.RawMessage, error)
+
+// Option configures a Vm at Compile time.
+type Option func(*compileOptions)
+
+type compileOptions struct {
+	functions  map[string]goFuncDef
+	includeEnv bool
+	moduleFS   fs.FS
+}
+
+type goFuncDef struct {
+	arity int
+	fn    GoFunc
+}
+
+// WithFunction registers a Go function under name, callable from the filter with
+// the given arity. This turns Compile's result from a pure filter runner into an
+// embeddable scripting layer: hosts can expose lookups, crypto, HTTP fetches,
+// database queries, or any other Go logic as an ordinary jq function.
+func WithFunction(name string, arity int, fn GoFunc) Option {
+	return func(o *compileOptions) {
+		if o.functions == nil {
+			o.functions = make(map[string]goFuncDef, 1)
+		}
+		o.functions[name] = goFuncDef{arity: arity, fn: fn}
+	}
+}
+
+// WithEnv additionally binds $ENV to the process's environment variables,
+// mirroring the jq CLI's default behaviour. CompileWithArgs does not bind $ENV
+// unless this option is given.
+func WithEnv() Option {
+	return func(o *compileOptions) {
+		o.includeEnv = true
+	}
+}
+
+// WithModuleFS makes jq modules available from fsys (e.g. one produced by
+// //go:embed), in addition to any directories passed to CompileWithModules. fsys
+// is extracted to a temporary directory for the lifetime of the Vm and removed on
+// Close, since neither backend's module loader can read a virtual filesystem
+// directly.
+func WithModuleFS(fsys fs.FS) Option {
+	return func(o *compileOptions) {
+		o.moduleFS = fsys
+	}
+}
+
+// materializeModuleFS copies every file in fsys into a fresh temporary directory,
+// returning its path. Used by every Compile* entry point in both backends to
+// turn a WithModuleFS filesystem into something their (filesystem-only) module
+// loaders can read.
+func materializeModuleFS(fsys fs.FS) (string, error) {
+	dir, err := os.MkdirTemp("", "jq-modules-")
+	if err != nil {
+		return "", err
+	}
+
+	err = fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		dst := filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dst, data, 0o644)
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// DumpOptions controls how Vm.RunOptions and Vm.DumpOptions render JQ values to
+// JSON text.
+type DumpOptions struct {
+	// Indent, if non-zero, pretty-prints with this many spaces per depth level.
+	// Zero (the default) produces compact, single-line output.
+	Indent int
+
+	// SortKeys, if true, emits object keys in sorted order. By default, as with
+	// the jq CLI, keys are emitted in the object's own (insertion) order.
+	SortKeys bool
+
+	// Color, if true, wraps each value kind in ANSI SGR escape sequences chosen
+	// by ColorScheme.
+	Color bool
+
+	// ColorScheme selects the colors used when Color is true. The zero value
+	// falls back to DefaultColorScheme.
+	ColorScheme ColorScheme
+}
+
+// ColorScheme assigns an ANSI SGR code (e.g. "1;30") to each of the six kinds of
+// token the dumper can emit, matching jq's own JQ_COLORS convention: null, bool,
+// number, string, object key, and punctuation (braces, brackets, comma, colon).
+type ColorScheme struct {
+	Null, Bool, Number, String, Key, Punct string
+}
+
+// DefaultColorScheme is used whenever DumpOptions.Color is set without an explicit
+// ColorScheme.
+var DefaultColorScheme = ColorScheme{
+	Null:   "1;30",
+	Bool:   "0;39",
+	Number: "0;39",
+	String: "0;32",
+	Key:    "1;34",
+	Punct:  "1;37",
+}
+
+// ColorSchemeFromSpec parses a JQ_COLORS-style spec string, six ANSI SGR codes
+// separated by colons in null:bool:number:string:key:punct order, e.g.
+// "1;30:0;37:0;37:0;37:0;32:1;37".
+func ColorSchemeFromSpec(spec string) (ColorScheme, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 6 {
+		return ColorScheme{}, fmt.Errorf("jq: color spec %q: expected 6 colon-separated fields, got %d", spec, len(parts))
+	}
+	return ColorScheme{
+		Null:   parts[0],
+		Bool:   parts[1],
+		Number: parts[2],
+		String: parts[3],
+		Key:    parts[4],
+		Punct:  parts[5],
+	}, nil
+}
+
+// ApplyWithArgs compiles proc via CompileWithArgs, and applies it to one or more inputs.
+func ApplyWithArgs(proc string, args map[string]interface{}, jsonArgs map[string]interface{}, input ...interface{}) ([][]byte, error) {
+	vm, err := CompileWithArgs(proc, args, jsonArgs)
+	defer vm.Close()
+	if err != nil {
+		return nil, err
+	}
+	return vm.Apply(input...)
+}
+
+// DumpWithArgs compiles proc via CompileWithArgs, and writes its results for each
+// input to w, following each with a newline.
+func DumpWithArgs(w io.Writer, proc string, args map[string]interface{}, jsonArgs map[string]interface{}, input ...interface{}) error {
+	vm, err := CompileWithArgs(proc, args, jsonArgs)
+	defer vm.Close()
+	if err != nil {
+		return err
+	}
+	return vm.Dump(w, input...)
+}
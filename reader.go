@@ -0,0 +1,105 @@
+//go:build cgo
+
+package jq
+
+// #include "jq.h"
+// #include <stdlib.h>
+import "C"
+import (
+	"io"
+	"unsafe"
+)
+
+// parserReadSize is the chunk size used when refilling the incremental JSON parser
+// from an io.Reader.
+const parserReadSize = 4096
+
+// RunReader streams values parsed incrementally from r through the compiled filter,
+// emitting each result on the returned channel. Unlike Run, the whole input does not
+// need to be materialised in memory first: r is read in chunks and fed to JQ's
+// streaming parser (jv_parser), so unbounded NDJSON or concatenated JSON (`{...}{...}`,
+// `1 2 3`) can be processed as it arrives. When the channel is closed, e will contain
+// the first parse or filter error encountered, if any. RunReader may be used
+// consecutively for additional readers, but not in parallel.
+func (s *Vm) RunReader(r io.Reader, e *error) <-chan []byte {
+	out := make(chan []byte)
+
+	go func() {
+		defer close(out)
+
+		parser := C.jv_parser_new(0)
+		defer C.jv_parser_free(parser)
+
+		buf := make([]byte, parserReadSize)
+		eof := false
+		for {
+			jv := C.jv_parser_next(parser)
+			if isValid(jv) {
+				err := processJq(s.jq, jv, func(val C.jv) error {
+					result, err := s.resolveFunctions(val)
+					if err != nil {
+						return err
+					}
+					defer freeJv(result)
+					if next := dumpJv(result); len(next) > 0 {
+						out <- next
+					}
+					return nil
+				})
+				if err != nil {
+					provideError(e, err)
+					return
+				}
+				continue
+			}
+			if err := jvError(jv); err != nil {
+				provideError(e, err)
+				return
+			}
+			if eof {
+				// parser is drained and has nothing further to report
+				return
+			}
+
+			// jv_parser_next returned invalid with no error: the parser needs more
+			// input before it can produce (or reject) the next value.
+			n, rerr := r.Read(buf)
+			if rerr != nil && rerr != io.EOF {
+				provideError(e, rerr)
+				return
+			}
+			eof = rerr == io.EOF
+
+			partial := C.int(1)
+			if eof {
+				partial = 0
+			}
+			// always pass a non-NULL buffer, even when n is 0: jv_parser_next treats a
+			// NULL buffer as "needs input" and would never flush a trailing value
+			// (such as the last number of `1 2 3`) at EOF.
+			C.jv_parser_set_buf(parser, (*C.char)(unsafe.Pointer(&buf[0])), C.int(n), partial)
+		}
+	}()
+	return out
+}
+
+// DumpReader compiles a JQ filter and streams it over values parsed incrementally
+// from r, writing each result to w followed by a newline. See Vm.RunReader for
+// details on streaming semantics.
+func DumpReader(w io.Writer, proc string, r io.Reader) error {
+	vm, err := Compile(proc)
+	defer vm.Close()
+	if err != nil {
+		return err
+	}
+	var rerr error
+	for item := range vm.RunReader(r, &rerr) {
+		if _, err := w.Write(item); err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte{'\n'}); err != nil {
+			return err
+		}
+	}
+	return rerr
+}
@@ -0,0 +1,41 @@
+//go:build cgo
+
+package jq_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/threatgrid/jq-go"
+)
+
+func TestRunReader(t *testing.T) {
+	vm, err := jq.Compile(`.`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vm.Close()
+
+	var rerr error
+	r := strings.NewReader(`1 2 3`)
+	var got []string
+	for item := range vm.RunReader(r, &rerr) {
+		got = append(got, string(item))
+	}
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	expectReturn(t, got, 1, 2, 3)
+}
+
+func TestDumpReader(t *testing.T) {
+	var buf bytes.Buffer
+	r := strings.NewReader(`{"a":1}{"a":2}`)
+	if err := jq.DumpReader(&buf, `.a`, r); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "1\n2\n" {
+		t.Errorf("expected: %q, got: %q", "1\n2\n", buf.String())
+	}
+}